@@ -0,0 +1,90 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prefetchlib
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/golang/glog"
+)
+
+// NewAdminHandler returns an http.Handler exposing a CRUD management API
+// over provider. It is meant to be served on a separate, localhost-only
+// admin port rather than alongside the public redirectHandler.
+//
+// lpURL values are full URLs and so may contain slashes; callers must
+// url.PathEscape the LP URL before putting it in the path. ServeMux (Go
+// 1.22+) already unescapes each {wildcard} path segment before PathValue
+// returns it, so the handlers below use it as-is -- unescaping it again
+// here would corrupt any lpURL that has its own percent-escapes.
+//
+//	GET    /admin/lp                                          all loaded LP URLs with their experiments' resources
+//	GET    /admin/lp/{escaped lpURL}/experiments/{id}          get one experiment's resources
+//	PUT    /admin/lp/{escaped lpURL}/experiments/{id}          set one experiment's resources
+//	DELETE /admin/lp/{escaped lpURL}/experiments/{id}          remove one experiment
+//	POST   /admin/reload                                       re-read the backing JSON file
+func NewAdminHandler(provider *Provider) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /admin/lp", func(rw http.ResponseWriter, req *http.Request) {
+		writeAdminJSON(rw, provider.Snapshot())
+	})
+
+	mux.HandleFunc("GET /admin/lp/{lpURL}/experiments/{id}", func(rw http.ResponseWriter, req *http.Request) {
+		resources, err := provider.GetPrefetchURLs(req.Context(), req.PathValue("lpURL"), req.PathValue("id"))
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeAdminJSON(rw, resources)
+	})
+
+	mux.HandleFunc("PUT /admin/lp/{lpURL}/experiments/{id}", func(rw http.ResponseWriter, req *http.Request) {
+		var resources []Resource
+		if err := json.NewDecoder(req.Body).Decode(&resources); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		provider.Set(req.PathValue("lpURL"), req.PathValue("id"), resources)
+		rw.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("DELETE /admin/lp/{lpURL}/experiments/{id}", func(rw http.ResponseWriter, req *http.Request) {
+		if !provider.Delete(req.PathValue("lpURL"), req.PathValue("id")) {
+			http.Error(rw, "", http.StatusNotFound)
+			return
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("POST /admin/reload", func(rw http.ResponseWriter, req *http.Request) {
+		if err := provider.Reload(""); err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	})
+
+	return mux
+}
+
+// writeAdminJSON encodes v as the JSON response body for an admin API call.
+func writeAdminJSON(rw http.ResponseWriter, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(v); err != nil {
+		glog.Errorf("failed to encode admin API response: %v", err)
+	}
+}
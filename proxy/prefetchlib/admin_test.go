@@ -0,0 +1,79 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prefetchlib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestProvider returns a Provider backed by an empty, temporary prefetch
+// URLs file.
+func newTestProvider(t *testing.T) *Provider {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "prefetchURLs.json")
+	if err := os.WriteFile(path, []byte(`[]`), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	provider, err := NewProvider(path)
+	if err != nil {
+		t.Fatalf("NewProvider: %v", err)
+	}
+	return provider
+}
+
+// TestAdminHandlerSlashContainingLPURL exercises the PUT/GET/DELETE
+// experiment routes with an lpURL that itself contains slashes, which
+// ServeMux cannot capture as a single path segment unless it is escaped.
+func TestAdminHandlerSlashContainingLPURL(t *testing.T) {
+	provider := newTestProvider(t)
+	mux := NewAdminHandler(provider)
+
+	lpURL := "http://example.com/landing"
+	path := "/admin/lp/" + url.PathEscape(lpURL) + "/experiments/exp1"
+	body := `[{"url":"http://cdn.example.com/r1.js","type":1,"priority":1}]`
+
+	putRec := httptest.NewRecorder()
+	mux.ServeHTTP(putRec, httptest.NewRequest(http.MethodPut, path, strings.NewReader(body)))
+	if putRec.Code != http.StatusNoContent {
+		t.Fatalf("PUT %s: got status %d, want %d", path, putRec.Code, http.StatusNoContent)
+	}
+
+	getRec := httptest.NewRecorder()
+	mux.ServeHTTP(getRec, httptest.NewRequest(http.MethodGet, path, nil))
+	if getRec.Code != http.StatusOK {
+		t.Fatalf("GET %s: got status %d, want %d", path, getRec.Code, http.StatusOK)
+	}
+	if !strings.Contains(getRec.Body.String(), "r1.js") {
+		t.Errorf("GET %s: body = %q, want it to contain r1.js", path, getRec.Body.String())
+	}
+
+	deleteRec := httptest.NewRecorder()
+	mux.ServeHTTP(deleteRec, httptest.NewRequest(http.MethodDelete, path, nil))
+	if deleteRec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE %s: got status %d, want %d", path, deleteRec.Code, http.StatusNoContent)
+	}
+
+	getAfterDeleteRec := httptest.NewRecorder()
+	mux.ServeHTTP(getAfterDeleteRec, httptest.NewRequest(http.MethodGet, path, nil))
+	if getAfterDeleteRec.Code != http.StatusNotFound {
+		t.Errorf("GET %s after delete: got status %d, want %d", path, getAfterDeleteRec.Code, http.StatusNotFound)
+	}
+}
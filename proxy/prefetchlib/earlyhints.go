@@ -0,0 +1,66 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prefetchlib
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// earlyHintsModeHeader and earlyHintsModeQueryParam select the HTTP/103
+// Early Hints delivery mode, as an alternative to the default templated-JS
+// DOM injection.
+const (
+	earlyHintsModeHeader     = "x-prefetch-mode"
+	earlyHintsModeQueryParam = "prefetch_mode"
+	earlyHintsModeValue      = "early-hints"
+)
+
+// wantsEarlyHints reports whether the request opted into HTTP/103 Early
+// Hints via the x-prefetch-mode header or the prefetch_mode query param.
+func wantsEarlyHints(req *http.Request) bool {
+	if req.Header.Get(earlyHintsModeHeader) == earlyHintsModeValue {
+		return true
+	}
+	return req.URL.Query().Get(earlyHintsModeQueryParam) == earlyHintsModeValue
+}
+
+// acceptsEarlyHints reports whether the client's Accept header allows an
+// informational 103 response. There is no standard way for a client to opt
+// out of 1xx responses, so this only honors the explicit "early-hints=0"
+// parameter some callers may set for testing or for clients known not to
+// handle 103 correctly.
+func acceptsEarlyHints(accept string) bool {
+	return !strings.Contains(accept, "early-hints=0")
+}
+
+// writeEarlyHints sends an HTTP/103 Early Hints informational response with
+// one "Link: <url>; rel=preload; as=<type>" header per resource, ahead of
+// the final response written by the caller.
+func writeEarlyHints(rw http.ResponseWriter, resources []Resource) error {
+	if err := http.NewResponseController(rw).EnableFullDuplex(); err != nil {
+		return fmt.Errorf("could not enable full duplex for early hints: %v", err)
+	}
+	header := rw.Header()
+	for _, resource := range resources {
+		header.Add("Link", fmt.Sprintf("<%s>; rel=preload; as=%s", resource.URL, resource.AsAttribute()))
+	}
+	rw.WriteHeader(http.StatusEarlyHints)
+	// The 103 response consumed the Link headers; clear them so they are
+	// not repeated verbatim on the final response.
+	header.Del("Link")
+	return nil
+}
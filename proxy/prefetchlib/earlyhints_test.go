@@ -0,0 +1,161 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prefetchlib
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+)
+
+// stubResolver is a Resolver returning a fixed set of resources (or error)
+// for every request, for use in tests.
+type stubResolver struct {
+	resources []Resource
+	err       error
+}
+
+func (s stubResolver) Resolve(ctx context.Context, lpURL, experimentID string) ([]Resource, error) {
+	return s.resources, s.err
+}
+
+// newTestHandler builds a redirectHandler the way New does, except the
+// html/template comes from an inline string rather than redirectTemplate:
+// the repo's static template asset isn't checked into this tree, so
+// template.ParseFiles(redirectTemplate) has nothing to read relative to the
+// test binary's working directory.
+func newTestHandler(t *testing.T, resolver Resolver) *redirectHandler {
+	t.Helper()
+	h := &redirectHandler{
+		htmlTemplate: template.Must(template.New("test").Parse(
+			`<html>{{range .PrefetchURLs}}<link rel="prefetch" href="{{.}}">{{end}}<script>{{.RedirectScript}}</script></html>`)),
+		resolver: resolver,
+		tracer:   otel.Tracer(instrumentationName),
+		meter:    otel.Meter(instrumentationName),
+	}
+	var err error
+	h.requestCounter, h.gzipSizeHistogram, err = newInstruments(h.meter)
+	if err != nil {
+		t.Fatalf("newInstruments: %v", err)
+	}
+	h.handler = http.HandlerFunc(h.resolveAndRespond)
+	return h
+}
+
+// TestEarlyHints verifies that opting into early-hints mode causes the
+// HTTP/103 Link preamble to be sent ahead of the final 200 response.
+func TestEarlyHints(t *testing.T) {
+	resolver := stubResolver{resources: []Resource{
+		{URL: "http://cdn.example.com/r1.js", Type: 1, Priority: 1},
+	}}
+	server := httptest.NewServer(newTestHandler(t, resolver))
+	defer server.Close()
+
+	reqURL := server.URL + "/?lp=" + url.QueryEscape("http://example.com/landing") +
+		"&prefetch=exp1&prefetch_mode=early-hints"
+	req, err := http.NewRequest(http.MethodGet, reqURL, nil)
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+
+	var got1xx []http.Header
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			got1xx = append(got1xx, http.Header(header).Clone())
+			return nil
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if len(got1xx) != 1 {
+		t.Fatalf("got %d 1xx responses, want 1", len(got1xx))
+	}
+	if links := got1xx[0]["Link"]; len(links) == 0 || !strings.Contains(links[0], "rel=preload") {
+		t.Errorf("103 Link header = %v, want it to contain rel=preload", links)
+	}
+	if _, ok := got1xx[0]["Content-Type"]; ok {
+		t.Errorf("103 response carried Content-Type %v, want none", got1xx[0]["Content-Type"])
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("final status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/html" {
+		t.Errorf("final Content-Type = %q, want text/html", ct)
+	}
+}
+
+// TestTemplatedJSFallback verifies that without an early-hints opt-in, the
+// resources are prefetched via the templated-JS DOM injection instead, with
+// no 1xx response sent.
+func TestTemplatedJSFallback(t *testing.T) {
+	resolver := stubResolver{resources: []Resource{
+		{URL: "http://cdn.example.com/r1.js", Type: 1, Priority: 1},
+	}}
+	server := httptest.NewServer(newTestHandler(t, resolver))
+	defer server.Close()
+
+	reqURL := server.URL + "/?lp=" + url.QueryEscape("http://example.com/landing") + "&prefetch=exp1"
+	req, err := http.NewRequest(http.MethodGet, reqURL, nil)
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+
+	got1xx := 0
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(int, textproto.MIMEHeader) error {
+			got1xx++
+			return nil
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if got1xx != 0 {
+		t.Errorf("got %d 1xx responses, want 0 (no early-hints opt-in)", got1xx)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("final status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	// http.Transport transparently gunzips when the caller didn't set its
+	// own Accept-Encoding, so resp.Body is already decompressed here.
+	body := &bytes.Buffer{}
+	if _, err := body.ReadFrom(resp.Body); err != nil {
+		t.Fatalf("ReadFrom: %v", err)
+	}
+	if !strings.Contains(body.String(), "r1.js") {
+		t.Errorf("body = %q, want it to contain r1.js", body.String())
+	}
+}
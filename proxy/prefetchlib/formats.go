@@ -0,0 +1,149 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prefetchlib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Prefetch-hint format identifiers, selectable via the x-prefetch-format
+// header or prefetch_format query param (or negotiated from Accept), for
+// requests made with x-via-header: 1.
+const (
+	// formatLegacy is the original `|$de|`-delimited x-prefetch header.
+	// Deprecated in favor of formatLink and formatJSON, but kept as the
+	// default for existing clients.
+	formatLegacy = "legacy"
+	// formatLink sends one RFC 8288 Link: rel=prefetch header per resource.
+	formatLink = "link"
+	// formatJSON replaces the response body with {"prefetch": [...]}.
+	formatJSON = "json"
+	// formatBinary replaces the response body with a compact binary
+	// encoding of the same data, for high-fanout deployments.
+	formatBinary = "binary"
+
+	prefetchFormatHeader     = "x-prefetch-format"
+	prefetchFormatQueryParam = "prefetch_format"
+)
+
+// negotiatePrefetchFormat picks a prefetch-hint format for req: the explicit
+// x-prefetch-format header or prefetch_format query param (in the
+// already-parsed query) wins, then the Accept header, and finally the
+// deprecated legacy delimited header.
+func negotiatePrefetchFormat(req *http.Request, query url.Values) string {
+	if format := req.Header.Get(prefetchFormatHeader); format != "" {
+		return format
+	}
+	if format := query.Get(prefetchFormatQueryParam); format != "" {
+		return format
+	}
+	switch accept := req.Header.Get("Accept"); {
+	case strings.Contains(accept, "application/json"):
+		return formatJSON
+	case strings.Contains(accept, "application/octet-stream"):
+		return formatBinary
+	}
+	return formatLegacy
+}
+
+// jsonPrefetchResource is the wire shape of one entry in the
+// {"prefetch": [...]} body written by writePrefetchJSON.
+type jsonPrefetchResource struct {
+	URL      string `json:"url"`
+	Type     string `json:"type"`
+	Priority int    `json:"priority"`
+}
+
+// writePrefetchJSON writes {"prefetch": [...]} describing resources as the
+// entire response body, for programmatic consumers that don't want the
+// templated redirect page.
+func writePrefetchJSON(rw http.ResponseWriter, resources []Resource) error {
+	body := struct {
+		Prefetch []jsonPrefetchResource `json:"prefetch"`
+	}{}
+	for _, resource := range resources {
+		body.Prefetch = append(body.Prefetch, jsonPrefetchResource{
+			URL:      resource.URL,
+			Type:     resource.AsAttribute(),
+			Priority: resource.Priority,
+		})
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(rw).Encode(body)
+}
+
+// writePrefetchLinkHeaders adds one RFC 8288 Link: rel=prefetch header per
+// resource, with `as` and `fetchpriority` parameters.
+func writePrefetchLinkHeaders(rw http.ResponseWriter, resources []Resource) {
+	header := rw.Header()
+	for _, resource := range resources {
+		header.Add("Link", fmt.Sprintf("<%s>; rel=prefetch; as=%s; fetchpriority=%s", resource.URL, resource.AsAttribute(), fetchPriority(resource.Priority)))
+	}
+}
+
+// fetchPriority maps the stored integer Priority to the fetchpriority Link
+// parameter token (https://wicg.github.io/priority-hints/#fetch-priority-attribute).
+func fetchPriority(priority int) string {
+	switch {
+	case priority > 0:
+		return "high"
+	case priority < 0:
+		return "low"
+	default:
+		return "auto"
+	}
+}
+
+// writePrefetchBinary writes a compact binary encoding of resources as the
+// entire response body: a big-endian uint32 count, followed by each
+// resource as a uint16 type, a zig-zag encoded uint16 priority, a uint16
+// URL length, and the URL bytes.
+func writePrefetchBinary(rw http.ResponseWriter, resources []Resource) error {
+	buf := &bytes.Buffer{}
+	if err := binary.Write(buf, binary.BigEndian, uint32(len(resources))); err != nil {
+		return err
+	}
+	for _, resource := range resources {
+		if err := binary.Write(buf, binary.BigEndian, uint16(resource.Type)); err != nil {
+			return err
+		}
+		if err := binary.Write(buf, binary.BigEndian, zigZagEncode(resource.Priority)); err != nil {
+			return err
+		}
+		urlBytes := []byte(resource.URL)
+		if err := binary.Write(buf, binary.BigEndian, uint16(len(urlBytes))); err != nil {
+			return err
+		}
+		if _, err := buf.Write(urlBytes); err != nil {
+			return err
+		}
+	}
+	rw.Header().Set("Content-Type", "application/octet-stream")
+	_, err := rw.Write(buf.Bytes())
+	return err
+}
+
+// zigZagEncode maps a signed priority to an unsigned uint16 so small
+// negative and positive values both encode compactly.
+func zigZagEncode(v int) uint16 {
+	n := int32(v)
+	return uint16(uint32((n << 1) ^ (n >> 31)))
+}
@@ -0,0 +1,146 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prefetchlib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// TestNegotiatePrefetchFormatPrecedence verifies that the explicit header
+// wins over the query param, which wins over Accept sniffing, which wins
+// over the legacy default.
+func TestNegotiatePrefetchFormatPrecedence(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		query  string
+		accept string
+		want   string
+	}{
+		{"header wins over everything", formatBinary, formatLink, "application/json", formatBinary},
+		{"query wins over Accept", "", formatLink, "application/json", formatLink},
+		{"Accept json", "", "", "application/json", formatJSON},
+		{"Accept octet-stream", "", "", "application/octet-stream", formatBinary},
+		{"falls back to legacy", "", "", "text/html", formatLegacy},
+		{"no Accept at all falls back to legacy", "", "", "", formatLegacy},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/?lp=x", nil)
+			if tc.header != "" {
+				req.Header.Set(prefetchFormatHeader, tc.header)
+			}
+			if tc.accept != "" {
+				req.Header.Set("Accept", tc.accept)
+			}
+			query := url.Values{}
+			if tc.query != "" {
+				query.Set(prefetchFormatQueryParam, tc.query)
+			}
+			if got := negotiatePrefetchFormat(req, query); got != tc.want {
+				t.Errorf("negotiatePrefetchFormat() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+var roundTripResources = []Resource{
+	{URL: "http://cdn.example.com/r1.js", Type: 1, Priority: 1},
+	{URL: "http://cdn.example.com/r2.css", Type: 2, Priority: -1},
+	{URL: "http://cdn.example.com/r3.png", Type: 3, Priority: 0},
+}
+
+// TestWritePrefetchJSONRoundTrip verifies writePrefetchJSON's body can be
+// decoded back into the same resources (modulo Type's int-to-string mapping).
+func TestWritePrefetchJSONRoundTrip(t *testing.T) {
+	rw := httptest.NewRecorder()
+	if err := writePrefetchJSON(rw, roundTripResources); err != nil {
+		t.Fatalf("writePrefetchJSON: %v", err)
+	}
+	if ct := rw.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+
+	var body struct {
+		Prefetch []jsonPrefetchResource `json:"prefetch"`
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &body); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	if len(body.Prefetch) != len(roundTripResources) {
+		t.Fatalf("got %d prefetch entries, want %d", len(body.Prefetch), len(roundTripResources))
+	}
+	for i, resource := range roundTripResources {
+		got := body.Prefetch[i]
+		if got.URL != resource.URL || got.Type != resource.AsAttribute() || got.Priority != resource.Priority {
+			t.Errorf("entry %d = %+v, want URL=%q Type=%q Priority=%d", i, got, resource.URL, resource.AsAttribute(), resource.Priority)
+		}
+	}
+}
+
+// TestWritePrefetchBinaryRoundTrip verifies writePrefetchBinary's wire
+// format can be decoded back into the original resources.
+func TestWritePrefetchBinaryRoundTrip(t *testing.T) {
+	rw := httptest.NewRecorder()
+	if err := writePrefetchBinary(rw, roundTripResources); err != nil {
+		t.Fatalf("writePrefetchBinary: %v", err)
+	}
+	if ct := rw.Header().Get("Content-Type"); ct != "application/octet-stream" {
+		t.Errorf("Content-Type = %q, want application/octet-stream", ct)
+	}
+
+	r := bytes.NewReader(rw.Body.Bytes())
+	var count uint32
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		t.Fatalf("read count: %v", err)
+	}
+	if int(count) != len(roundTripResources) {
+		t.Fatalf("count = %d, want %d", count, len(roundTripResources))
+	}
+	for i, want := range roundTripResources {
+		var typ uint16
+		var zigzag uint16
+		var urlLen uint16
+		if err := binary.Read(r, binary.BigEndian, &typ); err != nil {
+			t.Fatalf("entry %d: read type: %v", i, err)
+		}
+		if err := binary.Read(r, binary.BigEndian, &zigzag); err != nil {
+			t.Fatalf("entry %d: read priority: %v", i, err)
+		}
+		if err := binary.Read(r, binary.BigEndian, &urlLen); err != nil {
+			t.Fatalf("entry %d: read URL length: %v", i, err)
+		}
+		urlBytes := make([]byte, urlLen)
+		if _, err := r.Read(urlBytes); err != nil {
+			t.Fatalf("entry %d: read URL: %v", i, err)
+		}
+
+		priority := int32(zigzag>>1) ^ -int32(zigzag&1)
+		if int(typ) != want.Type {
+			t.Errorf("entry %d: type = %d, want %d", i, typ, want.Type)
+		}
+		if int(priority) != want.Priority {
+			t.Errorf("entry %d: priority = %d, want %d", i, priority, want.Priority)
+		}
+		if string(urlBytes) != want.URL {
+			t.Errorf("entry %d: URL = %q, want %q", i, urlBytes, want.URL)
+		}
+	}
+}
@@ -32,9 +32,17 @@
 package prefetchlib
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"sync"
+
+	"github.com/golang/glog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Resource defines the information of a prefetch resource in the JSON.
@@ -44,6 +52,26 @@ type Resource struct {
 	Priority int    `json:"priority"`
 }
 
+// AsAttribute maps Type to the W3C preload "as" token
+// (https://w3c.github.io/preload/#as-attribute). Early Hints and RFC 8288
+// Link headers and the JSON body all use it, so they describe a resource's
+// type the same way. The legacy x-prefetch header and the binary body carry
+// the integer Type instead.
+func (r Resource) AsAttribute() string {
+	switch r.Type {
+	case 1:
+		return "script"
+	case 2:
+		return "style"
+	case 3:
+		return "image"
+	case 4:
+		return "font"
+	default:
+		return "fetch"
+	}
+}
+
 // ExperimentPrefetchURLs represents the type to decode the prefetch URLs for a
 // particular experiment of a LP URL in the stored JSON file.
 type ExperimentPrefetchURLs struct {
@@ -58,21 +86,30 @@ type PrefetchURLs struct {
 }
 
 // Provider defines the type for retrieving the prefetch URLs based on the LP URL and
-// the experiment ID.
+// the experiment ID. It is safe for concurrent use, including concurrent
+// Reload/Set/Delete calls from the admin API while GetPrefetchURLs serves
+// requests.
 type Provider struct {
-	// Maps from LP URL to experiment ID to slice of prefetch URLs.
+	mu sync.RWMutex
+	// Maps from LP URL to experiment ID to slice of prefetch URLs. Guarded by mu.
 	urls map[string]map[string][]Resource
+	// filename is the file Reload re-reads from when called with an empty argument.
+	filename string
+
+	tracer trace.Tracer
 }
 
-// NewProvider returns a new prefetchurls.Provider. It takes in the name of the file containing
-// the prefetch URLs stored in JSON format (see example above).
-func NewProvider(filename string) (*Provider, error) {
+// loadPrefetchURLsFile reads and parses the prefetch URLs JSON file into the
+// map form stored by Provider.
+func loadPrefetchURLsFile(filename string) (map[string]map[string][]Resource, error) {
 	jsonStr, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return nil, err
 	}
 	var pfURLs []PrefetchURLs
-	json.Unmarshal(jsonStr, &pfURLs)
+	if err := json.Unmarshal(jsonStr, &pfURLs); err != nil {
+		return nil, fmt.Errorf("could not parse %q as prefetch URLs JSON: %v", filename, err)
+	}
 
 	urls := make(map[string]map[string][]Resource)
 	for _, pfURL := range pfURLs {
@@ -81,16 +118,127 @@ func NewProvider(filename string) (*Provider, error) {
 			urls[pfURL.LPURL][experimentPrefetchURL.ID] = experimentPrefetchURL.PrefetchURLs
 		}
 	}
-	return &Provider{urls: urls}, nil
+	return urls, nil
+}
+
+// ProviderOption configures optional behavior of NewProvider, mirroring
+// redirectHandler's Option. Use it so that a Provider passed to New as a
+// Resolver shares the same TracerProvider as the handler itself.
+type ProviderOption func(*Provider)
+
+// WithProviderTracerProvider makes the Provider create
+// Provider.GetPrefetchURLs spans with the given TracerProvider instead of
+// the global one returned by otel.GetTracerProvider.
+func WithProviderTracerProvider(tp trace.TracerProvider) ProviderOption {
+	return func(p *Provider) {
+		p.tracer = tp.Tracer(instrumentationName)
+	}
+}
+
+// NewProvider returns a new prefetchurls.Provider. It takes in the name of the file containing
+// the prefetch URLs stored in JSON format (see example above).
+func NewProvider(filename string, opts ...ProviderOption) (*Provider, error) {
+	urls, err := loadPrefetchURLsFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	provider := &Provider{
+		urls:     urls,
+		filename: filename,
+		tracer:   otel.Tracer(instrumentationName),
+	}
+	for _, opt := range opts {
+		opt(provider)
+	}
+	return provider, nil
 }
 
 // GetPrefetchURLs takes lpURL and experimentID and returns a slice of prefetch URLs.
 // When neither lpURL nor experimentID match any entry, the function returns nil with
 // an error.
-func (p *Provider) GetPrefetchURLs(lpURL, experimentID string) ([]Resource, error) {
+func (p *Provider) GetPrefetchURLs(ctx context.Context, lpURL, experimentID string) ([]Resource, error) {
+	ctx, span := p.tracer.Start(ctx, "Provider.GetPrefetchURLs")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("lp", lpURL),
+		attribute.String("experimentId", experimentID),
+	)
+
+	p.mu.RLock()
 	urls := p.urls[lpURL][experimentID]
+	p.mu.RUnlock()
 	if urls == nil {
-		return nil, fmt.Errorf("cannot find prefetch URL for %q, %q", lpURL, experimentID)
+		err := fmt.Errorf("cannot find prefetch URL for %q, %q", lpURL, experimentID)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
 	}
+	span.SetAttributes(attribute.Int("resourceCount", len(urls)))
 	return urls, nil
 }
+
+// Snapshot returns a copy of all currently loaded prefetch URLs, keyed by LP
+// URL and experiment ID. It is intended for the admin API's GET /admin/lp.
+func (p *Provider) Snapshot() map[string]map[string][]Resource {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	snapshot := make(map[string]map[string][]Resource, len(p.urls))
+	for lpURL, experiments := range p.urls {
+		snapshot[lpURL] = make(map[string][]Resource, len(experiments))
+		for id, resources := range experiments {
+			snapshot[lpURL][id] = resources
+		}
+	}
+	return snapshot
+}
+
+// Set stores (or replaces) the prefetch resources for the given LP URL and
+// experiment ID. The change is held in memory only; it is not persisted
+// back to the backing file.
+func (p *Provider) Set(lpURL, experimentID string, resources []Resource) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.urls[lpURL] == nil {
+		p.urls[lpURL] = make(map[string][]Resource)
+	}
+	p.urls[lpURL][experimentID] = resources
+}
+
+// Delete removes the prefetch resources for the given LP URL and experiment
+// ID, if present, and reports whether an entry was removed.
+func (p *Provider) Delete(lpURL, experimentID string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	experiments, ok := p.urls[lpURL]
+	if !ok {
+		return false
+	}
+	if _, ok := experiments[experimentID]; !ok {
+		return false
+	}
+	delete(experiments, experimentID)
+	return true
+}
+
+// Reload re-reads the prefetch URLs from filename and atomically swaps them
+// in. If filename is empty, it re-reads the file the Provider was
+// constructed with (or last successfully reloaded from). A JSON parsing
+// error is reported via glog rather than silently discarded, and leaves the
+// previously loaded URLs in place.
+func (p *Provider) Reload(filename string) error {
+	if filename == "" {
+		p.mu.RLock()
+		filename = p.filename
+		p.mu.RUnlock()
+	}
+	urls, err := loadPrefetchURLsFile(filename)
+	if err != nil {
+		glog.Errorf("failed to reload prefetch URLs from %q: %v", filename, err)
+		return err
+	}
+	p.mu.Lock()
+	p.urls = urls
+	p.filename = filename
+	p.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,44 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prefetchlib
+
+import (
+	"context"
+	"net/http"
+)
+
+// Resolver looks up the prefetch resources for a landing page URL and
+// experiment ID. Provider is the file-backed implementation used by
+// default; callers may supply their own, e.g. backed by Redis, an RPC to
+// another service, or a model that ranks candidate resources.
+type Resolver interface {
+	Resolve(ctx context.Context, lpURL, experimentID string) ([]Resource, error)
+}
+
+// Resolve implements Resolver by delegating to GetPrefetchURLs.
+func (p *Provider) Resolve(ctx context.Context, lpURL, experimentID string) ([]Resource, error) {
+	return p.GetPrefetchURLs(ctx, lpURL, experimentID)
+}
+
+// Middleware wraps an http.Handler to add behavior -- such as
+// authentication or request validation -- around resolveAndRespond, which
+// resolves the prefetch resources and writes the whole response itself
+// (format negotiation, Early Hints, and the gzip-encoded template). New
+// applies middlewares in the order given, so the first entry is outermost
+// and sees the request first. Middleware wraps that single pipeline as a
+// whole; it is not yet decomposed into separately pluggable resolver and
+// response-encoding stages, so a middleware cannot swap out just the
+// encoding step.
+type Middleware func(http.Handler) http.Handler
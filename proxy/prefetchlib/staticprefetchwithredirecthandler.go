@@ -21,6 +21,7 @@ package prefetchlib
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"fmt"
   "html/template"
 	"io"
@@ -29,6 +30,12 @@ import (
 	"strings"
 
   "github.com/golang/glog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -42,26 +49,88 @@ const (
 
 // Handler defines the prefetchproxyhandler.Handler type.
 type redirectHandler struct {
-	htmlTemplate        *template.Template     // The stub to be sent back with the initial response.
-	prefetchURLProvider *Provider              // The instance for looking up the prefetch URLs.
+	htmlTemplate *template.Template // The stub to be sent back with the initial response.
+	resolver     Resolver           // The instance for looking up the prefetch URLs.
+	handler      http.Handler       // resolveAndRespond wrapped by the configured middleware chain.
+
+	tracer            trace.Tracer
+	meter             metric.Meter
+	requestCounter    metric.Int64Counter
+	gzipSizeHistogram metric.Int64Histogram
+
+	earlyHintsDisabled bool // Disables the HTTP/103 Early Hints mode even when a request opts in.
 }
 
-// New returns a new prefetchproxyhandler object.
-func New(prefetchURLsFilename string) (*redirectHandler, error) {
-	prefetchURLProvider, err := NewProvider(prefetchURLsFilename)
+// New returns a new prefetchproxyhandler object backed by resolver. The
+// default file-based Provider satisfies Resolver, but so can a Redis-backed
+// lookup, a call to another service, or anything else that can answer
+// Resolve -- that part is fully pluggable. The response-encoding side (Early
+// Hints, gzip, and the templated HTML/x-prefetch header) is not: it is
+// still private to redirectHandler, so a deployment that wants to reuse the
+// resolver lookup with a different response format has to fork that logic
+// rather than swap it in. middlewares are applied around the whole
+// resolver+encoding pipeline in the order given, so the first entry is
+// outermost and sees the request first; see Middleware. By default New uses
+// the global OpenTelemetry TracerProvider and MeterProvider; pass
+// WithTracerProvider and/or WithMeterProvider to override either.
+func New(resolver Resolver, middlewares []Middleware, opts ...Option) (*redirectHandler, error) {
+	newHandler := &redirectHandler{
+		htmlTemplate: template.Must(template.ParseFiles(redirectTemplate)),
+		resolver:     resolver,
+		tracer:       otel.Tracer(instrumentationName),
+		meter:        otel.Meter(instrumentationName),
+	}
+	for _, opt := range opts {
+		opt(newHandler)
+	}
+
+	var err error
+	newHandler.requestCounter, newHandler.gzipSizeHistogram, err = newInstruments(newHandler.meter)
 	if err != nil {
 		return nil, err
 	}
 
-	newHandler := &redirectHandler{
-		htmlTemplate:        template.Must(template.ParseFiles(redirectTemplate)),
-		prefetchURLProvider: prefetchURLProvider,
+	var h http.Handler = http.HandlerFunc(newHandler.resolveAndRespond)
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
 	}
+	newHandler.handler = h
 	return newHandler, nil
 }
 
-// Implements the handle function for serving a HTTP request.
+// ServeHTTP runs the configured middleware chain, ending in
+// resolveAndRespond.
 func (h *redirectHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	h.handler.ServeHTTP(rw, req)
+}
+
+// resolveAndRespond looks up the prefetch resources via h.resolver and
+// writes the response: a JSON or binary body when an x-via-header request
+// negotiates one, otherwise the templated redirect-with-prefetch page. It is the
+// innermost handler in the middleware chain built by New.
+func (h *redirectHandler) resolveAndRespond(rw http.ResponseWriter, req *http.Request) {
+	ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+	ctx, span := h.tracer.Start(ctx, "redirectHandler.resolveAndRespond")
+	req = req.WithContext(ctx)
+	defer span.End()
+
+	experimentID := ""
+	status := "ok"
+	defer func() {
+		h.requestCounter.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("experiment", experimentID),
+			attribute.String("status", status),
+		))
+	}()
+	recordError := func(err error, code int) {
+		status = "error"
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		if code != 0 {
+			http.Error(rw, "", code)
+		}
+	}
+
 	if !req.URL.IsAbs() {
 		req.URL.Scheme = "http"
 		req.URL.Host = req.Host
@@ -78,23 +147,25 @@ func (h *redirectHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	query := req.URL.Query()
 	lp := query.Get("lp")
   if lp == "" {
-		glog.Errorf("got an invalid request: %v", req.URL)
-		http.Error(rw, "", http.StatusBadRequest)
+		err := fmt.Errorf("got an invalid request: %v", req.URL)
+		glog.Error(err)
+		recordError(err, http.StatusBadRequest)
 		return
   }
 
 	unescapedURL, err := url.PathUnescape(lp)
 	if err != nil {
 		glog.Errorf("could not unescape lp query parameter: %v", lp)
-		http.Error(rw, "", http.StatusBadRequest)
+		recordError(err, http.StatusBadRequest)
 	}
 
 	dstURL, err := url.Parse(unescapedURL)
 	if err != nil {
 		glog.Errorf("could not parse URL: %v", req.URL)
-		http.Error(rw, "", http.StatusBadRequest)
+		recordError(err, http.StatusBadRequest)
 	}
 	rw.Header().Set("x-lp-url", dstURL.String())
+	span.SetAttributes(attribute.String("lp", dstURL.String()))
 
 	prefetchURLs := []Resource{}
 	prefetch := req.Header.Get("x-req-prefetch")
@@ -102,65 +173,132 @@ func (h *redirectHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
     prefetch = query.Get("prefetch")
   }
 	if prefetch != "" {
-		prefetchURLsStored, err := h.prefetchURLProvider.GetPrefetchURLs(dstURL.String(), prefetch)
+		experimentID = prefetch
+		span.SetAttributes(attribute.String("experimentId", prefetch))
+		prefetchURLsStored, err := h.resolver.Resolve(ctx, dstURL.String(), prefetch)
 		if err != nil {
 			glog.Errorf("failed to get prefetch URLs for %v with experiment ID: %v error: %v", dstURL.String(), prefetch, err)
-			http.Error(rw, "", http.StatusInternalServerError)
+			recordError(err, http.StatusInternalServerError)
 			return
 		}
 		prefetchURLs = append(prefetchURLs, prefetchURLsStored...)
 	} else {
 	  glog.Infof("No prefetching requested. Just redirecting to %v", dstURL.String())
   }
+	span.SetAttributes(attribute.Int("resourceCount", len(prefetchURLs)))
 
-	rw.Header().Set("Content-Type", "text/html")
 	rw.Header().Set("Referrer-Policy", "no-referrer")
 	rw.Header().Set("Access-Control-Allow-Origin", "*")
-	rw.Header().Set("Content-Encoding", "gzip")
 
 	returnViaHTTPHeader := req.Header.Get("x-via-header")
+	span.SetAttributes(attribute.Bool("viaHeader", returnViaHTTPHeader == "1"))
 	if returnViaHTTPHeader == "1" {
-		// Put the rest in a HTTP header.
+		if done := writeViaHeaderFormat(span, rw, req, query, prefetchURLs, recordError); done {
+			return
+		}
+		prefetchURLs = nil
+	}
+
+	h.writeTemplatedResponse(ctx, span, rw, req, dstURL, prefetchURLs, experimentID, recordError)
+}
+
+// writeViaHeaderFormat encodes prefetchURLs in whichever format req
+// negotiated for x-via-header: 1 requests (JSON, binary, Link headers, or
+// the legacy delimited header). It reports whether it fully wrote and
+// completed the response (JSON and binary replace the body entirely; Link
+// and the legacy header only set headers and let the caller continue on to
+// the templated response).
+func writeViaHeaderFormat(span trace.Span, rw http.ResponseWriter, req *http.Request, query url.Values, prefetchURLs []Resource, recordError func(error, int)) (done bool) {
+	format := negotiatePrefetchFormat(req, query)
+	span.SetAttributes(attribute.String("prefetchFormat", format))
+	switch format {
+	case formatJSON:
+		if err := writePrefetchJSON(rw, prefetchURLs); err != nil {
+			glog.Errorf("failed to write JSON prefetch response: %v", err)
+			recordError(err, 0)
+		}
+		return true
+	case formatBinary:
+		if err := writePrefetchBinary(rw, prefetchURLs); err != nil {
+			glog.Errorf("failed to write binary prefetch response: %v", err)
+			recordError(err, 0)
+		}
+		return true
+	case formatLink:
+		writePrefetchLinkHeaders(rw, prefetchURLs)
+	default:
+		// Deprecated: the `|$de|`-delimited x-prefetch header, kept for
+		// existing clients. New integrations should request formatLink or
+		// formatJSON via x-prefetch-format.
 		prefetchHeaderValue := []string{}
 		for _, url := range prefetchURLs {
 			prefetchHeaderValue = append(prefetchHeaderValue, generatePrefetchHeaderString(url))
 		}
 		rw.Header().Set("x-prefetch", strings.Join(prefetchHeaderValue, delim))
-		prefetchURLs = nil
 	}
-	writer, err := gzip.NewWriterLevel(rw, gzip.BestCompression)
+	return false
+}
+
+// writeTemplatedResponse writes the default response: an HTTP/103 Early
+// Hints preamble when requested and available, followed by the gzip-encoded
+// templated HTML page that redirects to dstURL and, when Early Hints wasn't
+// used, injects <link rel="prefetch"> via DOM-injection JS instead.
+func (h *redirectHandler) writeTemplatedResponse(ctx context.Context, span trace.Span, rw http.ResponseWriter, req *http.Request, dstURL *url.URL, prefetchURLs []Resource, experimentID string, recordError func(error, int)) {
+	// Decide on and send the Early Hints preamble, if any, before setting
+	// Content-Type/Content-Encoding below: those describe the gzip body
+	// written later and have no place on the informational 103 response.
+	useEarlyHints := len(prefetchURLs) > 0 && !h.earlyHintsDisabled && wantsEarlyHints(req) && acceptsEarlyHints(req.Header.Get("Accept"))
+	span.SetAttributes(attribute.Bool("earlyHints", useEarlyHints))
+	if useEarlyHints {
+		if err := writeEarlyHints(rw, prefetchURLs); err != nil {
+			glog.Warningf("falling back to templated-JS prefetch: %v", err)
+			useEarlyHints = false
+		}
+	}
+
+	rw.Header().Set("Content-Type", "text/html")
+	rw.Header().Set("Content-Encoding", "gzip")
+
+	counter := &countingWriter{w: rw}
+	writer, err := gzip.NewWriterLevel(counter, gzip.BestCompression)
 	if err != nil {
 		glog.Errorf("failed to get gzip writer: %v", err)
-		http.Error(rw, "", http.StatusBadGateway)
+		recordError(err, http.StatusBadGateway)
 		return
 	}
-	defer writer.Close()
+	defer func() {
+		writer.Close()
+		h.gzipSizeHistogram.Record(ctx, counter.bytes, metric.WithAttributes(attribute.String("experiment", experimentID)))
+	}()
 
-	// Generate the snippet for navigating to the final page.
+	// Generate the snippet for navigating to the final page. When the
+	// resources were already announced via Early Hints, the DOM-injection
+	// JS doesn't need to prefetch them again.
 	var URLs []string
-	for _, pfURL := range prefetchURLs {
-		URLs = append(URLs, pfURL.URL)
+	if !useEarlyHints {
+		for _, pfURL := range prefetchURLs {
+			URLs = append(URLs, pfURL.URL)
+		}
 	}
 
 	templateData := struct {
 		PrefetchURLs   []string
 		RedirectScript template.JS
 	}{
-		PrefetchURLs: URLs,
-    RedirectScript: template.JS(fmt.Sprintf("var dstURL='%s';\nwindow.location.assign(dstURL);", dstURL.String())),
+		PrefetchURLs:   URLs,
+		RedirectScript: template.JS(fmt.Sprintf("var dstURL='%s';\nwindow.location.assign(dstURL);", dstURL.String())),
 	}
 	targetPageBuf := &bytes.Buffer{}
-	err = h.htmlTemplate.Execute(targetPageBuf, templateData)
-	if err != nil {
+	if err := h.htmlTemplate.Execute(targetPageBuf, templateData); err != nil {
 		glog.Errorf("template.Execute: %v\n", err)
-		http.Error(rw, "", http.StatusBadGateway)
+		recordError(err, http.StatusBadGateway)
 		return
 	}
-  glog.V(5).Infof("%v", targetPageBuf)
+	glog.V(5).Infof("%v", targetPageBuf)
 
-	_, err = io.Copy(writer, targetPageBuf)
-	if err != nil {
+	if _, err := io.Copy(writer, targetPageBuf); err != nil {
 		glog.Errorf("error writing to network: %v", err)
+		recordError(err, 0)
 	}
 }
 
@@ -0,0 +1,95 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prefetchlib
+
+import (
+	"io"
+
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName identifies this package as the source of the spans
+// and metrics it produces.
+const instrumentationName = "google/http_prefetching/proxy/prefetchlib"
+
+// Option configures optional behavior of New, such as plugging in
+// non-default OpenTelemetry providers.
+type Option func(*redirectHandler)
+
+// WithTracerProvider makes the handler create spans with the given
+// TracerProvider instead of the global one returned by
+// otel.GetTracerProvider. A Provider passed to New as the Resolver builds
+// its own spans independently; give it the same TracerProvider via the
+// analogous WithProviderTracerProvider option on NewProvider.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(h *redirectHandler) {
+		h.tracer = tp.Tracer(instrumentationName)
+	}
+}
+
+// WithMeterProvider makes the handler record metrics with the given
+// MeterProvider instead of the global one returned by otel.GetMeterProvider.
+func WithMeterProvider(mp metric.MeterProvider) Option {
+	return func(h *redirectHandler) {
+		h.meter = mp.Meter(instrumentationName)
+	}
+}
+
+// DisableEarlyHints prevents the handler from ever sending HTTP/103 Early
+// Hints, even when a request opts in via x-prefetch-mode: early-hints. Use
+// this for deployments behind infrastructure that mishandles 1xx responses.
+func DisableEarlyHints() Option {
+	return func(h *redirectHandler) {
+		h.earlyHintsDisabled = true
+	}
+}
+
+// newInstruments builds the counters and histograms recorded by
+// redirectHandler.ServeHTTP. It is split out of New so that the fallible
+// metric.Meter.Int64Counter/Int64Histogram calls have a single place to
+// report errors from.
+func newInstruments(meter metric.Meter) (requestCounter metric.Int64Counter, gzipSize metric.Int64Histogram, err error) {
+	requestCounter, err = meter.Int64Counter(
+		"prefetch_requests_total",
+		metric.WithDescription("Number of prefetch proxy requests, by experiment and outcome."),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	gzipSize, err = meter.Int64Histogram(
+		"prefetch_gzip_response_size_bytes",
+		metric.WithDescription("Size in bytes of the gzip-encoded response body written to the client."),
+		metric.WithUnit("By"),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	return requestCounter, gzipSize, nil
+}
+
+// countingWriter wraps an io.Writer and tracks the number of bytes that have
+// passed through it, so the gzip-encoded response size can be recorded after
+// the gzip writer has flushed.
+type countingWriter struct {
+	w     io.Writer
+	bytes int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.bytes += int64(n)
+	return n, err
+}
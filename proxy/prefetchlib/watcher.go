@@ -0,0 +1,84 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prefetchlib
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/golang/glog"
+)
+
+// WatchForChanges watches the Provider's backing file with fsnotify and
+// calls Reload whenever it is written or recreated (editors and deploy
+// tooling commonly replace a file with an atomic rename rather than writing
+// in place). It watches the file's containing directory rather than the
+// file itself: a watch on the file path follows the inode, and an atomic
+// rename over it delivers one Remove event and then leaves the watch dead,
+// so a directory watch filtered by basename is required to survive more
+// than one such replacement. The watch goroutine runs until stop is
+// closed; passing a nil stop channel watches indefinitely.
+func (p *Provider) WatchForChanges(stop <-chan struct{}) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("could not create file watcher: %v", err)
+	}
+
+	p.mu.RLock()
+	filename := p.filename
+	p.mu.RUnlock()
+	dir := filepath.Dir(filename)
+	base := filepath.Base(filename)
+	if err := watcher.Add(dir); err != nil {
+		watcher.Close()
+		return fmt.Errorf("could not watch %q: %v", dir, err)
+	}
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Base(event.Name) != base {
+					continue
+				}
+				// The watch is on the directory (not the file itself), so
+				// it survives an atomic replace -- a rename(2) over
+				// filename, which editors and deploy tooling commonly use
+				// instead of writing in place -- which otherwise would
+				// have delivered one Remove event and left the watch
+				// dead. The replacement shows up here as Create.
+				if event.Has(fsnotify.Write) || event.Has(fsnotify.Create) {
+					glog.Infof("%v changed, reloading prefetch URLs", event.Name)
+					if err := p.Reload(""); err != nil {
+						glog.Errorf("failed to reload prefetch URLs after %v: %v", event, err)
+					}
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				glog.Errorf("file watcher error for %q: %v", dir, err)
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
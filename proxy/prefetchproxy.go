@@ -19,12 +19,19 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net/http"
 
-	"./prefetchlib"
 	"github.com/golang/glog"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/trace"
+	"google/http_prefetching/proxy/prefetchlib"
 )
 
 var (
@@ -32,14 +39,75 @@ var (
 	certFile             = flag.String("cert_file", "mycert.pem", "The SSL certificate file.")
 	keyFile              = flag.String("key_file", "mykey.pem", "The SSL key file.")
 	prefetchURLsFilename = flag.String("prefetch_urls", "prefetchURLs.json", "The file containing the URLs to be fetched in JSON format described in prefetchurls.go")
+	otlpEndpoint         = flag.String("otlp_endpoint", "", "OTLP/HTTP collector endpoint (host:port) to export traces and metrics to. Tracing and metrics are disabled if empty.")
+	adminPort            = flag.Int("admin_port", 8081, "The localhost-only port serving the CRUD management API for prefetch URLs.")
+	watchPrefetchURLs    = flag.Bool("watch_prefetch_urls", true, "Whether to watch -prefetch_urls for changes and reload automatically.")
 )
 
+// setupTelemetry wires up an OTLP-HTTP exporter pointed at *otlpEndpoint and
+// registers it as the global TracerProvider and MeterProvider. It returns a
+// shutdown function to flush and close the exporters on exit. If no
+// endpoint is configured, it is a no-op and the global no-op providers are
+// left in place.
+func setupTelemetry(ctx context.Context) (shutdown func(context.Context) error, err error) {
+	if *otlpEndpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	traceExporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpoint(*otlpEndpoint), otlptracehttp.WithInsecure())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP trace exporter: %v", err)
+	}
+	tracerProvider := trace.NewTracerProvider(trace.WithBatcher(traceExporter))
+	otel.SetTracerProvider(tracerProvider)
+
+	metricExporter, err := otlpmetrichttp.New(ctx, otlpmetrichttp.WithEndpoint(*otlpEndpoint), otlpmetrichttp.WithInsecure())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP metric exporter: %v", err)
+	}
+	meterProvider := metric.NewMeterProvider(metric.WithReader(metric.NewPeriodicReader(metricExporter)))
+	otel.SetMeterProvider(meterProvider)
+
+	return func(shutdownCtx context.Context) error {
+		if err := tracerProvider.Shutdown(shutdownCtx); err != nil {
+			return err
+		}
+		return meterProvider.Shutdown(shutdownCtx)
+	}, nil
+}
+
 func main() {
-	handler, err := prefetchlib.New(*prefetchURLsFilename)
+	flag.Parse()
+
+	shutdownTelemetry, err := setupTelemetry(context.Background())
+	if err != nil {
+		glog.Fatalf("Failed to set up OpenTelemetry: %v\n", err)
+	}
+	defer shutdownTelemetry(context.Background())
+
+	provider, err := prefetchlib.NewProvider(*prefetchURLsFilename)
+	if err != nil {
+		glog.Fatalf("Failed to create prefetch URL provider: %v\n", err)
+	}
+	handler, err := prefetchlib.New(provider, nil)
 	if err != nil {
 		glog.Fatal("Failed to create Prefetch Proxy Handler handler: %v\n", err)
 	}
-	http.Handle("/", handler)
+	http.Handle("/", otelhttp.NewHandler(handler, "prefetchproxy"))
+
+	if *watchPrefetchURLs {
+		if err := provider.WatchForChanges(nil); err != nil {
+			glog.Warningf("could not watch %v for changes: %v", *prefetchURLsFilename, err)
+		}
+	}
+
+	adminServer := &http.Server{
+		Addr:    fmt.Sprintf("127.0.0.1:%d", *adminPort),
+		Handler: prefetchlib.NewAdminHandler(provider),
+	}
+	go func() {
+		glog.Errorf("admin server exited: %v", adminServer.ListenAndServe())
+	}()
 
 	server := &http.Server{
 		Addr: fmt.Sprintf(":%d", *port),